@@ -0,0 +1,120 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+const (
+	// bootloaderTouchBaud is the well-known baud rate that tells native-USB
+	// Arduino boards (Leonardo, Micro, MKR, Nano 33 family, ...) to jump to
+	// their USB bootloader when the port is opened and promptly closed.
+	bootloaderTouchBaud = 1200
+	// bootloaderTouchHoldTime is how long the port is held open at
+	// bootloaderTouchBaud before being closed again.
+	bootloaderTouchHoldTime = 100 * time.Millisecond
+	// bootloaderEnumeratePoll is how often GetPortsList is polled while
+	// waiting for the board to disappear and reappear.
+	bootloaderEnumeratePoll = 250 * time.Millisecond
+)
+
+func (d *SerialMonitor) bootloaderEnumTimeout() time.Duration {
+	ms, err := strconv.Atoi(d.serialSettings.ConfigurationParameter["bootloader_enum_timeout_ms"].Selected)
+	if err != nil || ms <= 0 {
+		ms = 3000
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// touchForBootloader performs the "1200bps touch": it opens boardPort at
+// bootloaderTouchBaud with DTR asserted, holds it briefly, then closes it,
+// which causes native-USB Arduino boards to reset into their USB
+// bootloader. It then waits for boardPort to disappear and re-enumerate
+// before returning the port the caller should open at the real settings.
+//
+// On Linux and macOS, a native-USB board commonly re-enumerates under a
+// different device node (e.g. /dev/ttyACM0 -> /dev/ttyACM1) rather than
+// reappearing as boardPort, so this diffs GetPortsList before/after the
+// touch and returns whichever new port shows up instead of insisting on
+// boardPort specifically.
+func touchForBootloader(boardPort string, enumTimeout time.Duration) (string, error) {
+	before, _ := serial.GetPortsList()
+
+	touchPort, err := serial.Open(boardPort, &serial.Mode{
+		BaudRate: bootloaderTouchBaud,
+		InitialStatusBits: &serial.ModemOutputBits{
+			DTR: true,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not open %s at %dbps to trigger bootloader reset: %w", boardPort, bootloaderTouchBaud, err)
+	}
+	time.Sleep(bootloaderTouchHoldTime)
+	touchPort.Close()
+
+	deadline := time.Now().Add(enumTimeout)
+	disappeared := false
+	for time.Now().Before(deadline) {
+		ports, _ := serial.GetPortsList()
+		present := portListContains(ports, boardPort)
+		if disappeared {
+			if present {
+				return boardPort, nil
+			}
+			if newPort, ok := newPortSince(before, ports); ok {
+				return newPort, nil
+			}
+		}
+		if !present {
+			disappeared = true
+		}
+		time.Sleep(bootloaderEnumeratePoll)
+	}
+	return "", fmt.Errorf("port %s did not reappear within %s after bootloader reset", boardPort, enumTimeout)
+}
+
+func portListContains(ports []string, boardPort string) bool {
+	for _, p := range ports {
+		if p == boardPort {
+			return true
+		}
+	}
+	return false
+}
+
+// newPortSince returns a port present in after but not before, if there is
+// exactly one such port (the common case for a single board reset into its
+// bootloader). It's ambiguous with more than one, so that case is left for
+// the boardPort-reappears check instead.
+func newPortSince(before, after []string) (string, bool) {
+	var added []string
+	for _, p := range after {
+		if !portListContains(before, p) {
+			added = append(added, p)
+		}
+	}
+	if len(added) == 1 {
+		return added[0], true
+	}
+	return "", false
+}
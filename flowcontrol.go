@@ -0,0 +1,211 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// ctsPollInterval is how often rtsCtsReadWriter samples the CTS modem status
+// bit while go.bug.st/serial has no event-based API for it.
+const ctsPollInterval = 20 * time.Millisecond
+
+// rtsCtsReadWriter wraps a serial.Port and suspends Write calls while CTS is
+// deasserted, emulating hardware flow control for platforms/backends where
+// the driver doesn't manage RTS/CTS itself.
+type rtsCtsReadWriter struct {
+	serial.Port
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ctsOn  bool
+	closed bool
+}
+
+func newRTSCTSReadWriter(port serial.Port) *rtsCtsReadWriter {
+	w := &rtsCtsReadWriter{
+		Port:  port,
+		quit:  make(chan struct{}),
+		ctsOn: true,
+	}
+	w.cond = sync.NewCond(&w.mu)
+	w.wg.Add(1)
+	go w.pollCTS()
+	return w
+}
+
+func (w *rtsCtsReadWriter) pollCTS() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(ctsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-ticker.C:
+			bits, err := w.Port.GetModemStatusBits()
+			if err != nil {
+				continue
+			}
+			w.mu.Lock()
+			if w.ctsOn != bits.CTS {
+				w.ctsOn = bits.CTS
+				w.cond.Broadcast()
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// Write blocks until CTS is asserted (or the wrapper is closed) before
+// forwarding data to the underlying port.
+func (w *rtsCtsReadWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	for !w.ctsOn && !w.closed {
+		w.cond.Wait()
+	}
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return 0, fmt.Errorf("port closed while waiting for CTS")
+	}
+	return w.Port.Write(p)
+}
+
+// Close stops the CTS polling goroutine. It does not close the underlying
+// port, which remains owned by SerialMonitor.
+func (w *rtsCtsReadWriter) Close() error {
+	close(w.quit)
+	w.wg.Wait()
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return nil
+}
+
+// XON/XOFF control bytes as used by software flow control.
+const (
+	xonByte  = 0x11
+	xoffByte = 0x13
+)
+
+// xonXoffWriteChunk bounds how much data a single blocked Write call hands to
+// the underlying port once XOFF is lifted, so a paused writer doesn't have to
+// buffer an arbitrarily large caller-supplied slice.
+const xonXoffWriteChunk = 4096
+
+// xonXoffReadWriter wraps a serial.Port, filtering XON/XOFF bytes sent by the
+// device out of the read stream and pausing/resuming writes accordingly.
+type xonXoffReadWriter struct {
+	serial.Port
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+	closed bool
+}
+
+func newXonXoffReadWriter(port serial.Port) *xonXoffReadWriter {
+	w := &xonXoffReadWriter{Port: port}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Read forwards data from the port, stripping XON/XOFF control bytes and
+// using them to pause/resume outbound Write calls. If a read returns only
+// control bytes, it loops internally rather than handing the caller a
+// (0, nil) result: io.Reader callers are entitled to retry that immediately,
+// which would busy-spin on a device that bursts flow-control bytes with no
+// payload.
+func (w *xonXoffReadWriter) Read(p []byte) (int, error) {
+	for {
+		n, err := w.Port.Read(p)
+		if n == 0 {
+			return n, err
+		}
+		filtered := p[:0]
+		for _, b := range p[:n] {
+			switch b {
+			case xonByte:
+				w.setPaused(false)
+			case xoffByte:
+				w.setPaused(true)
+			default:
+				filtered = append(filtered, b)
+			}
+		}
+		if len(filtered) > 0 || err != nil {
+			return len(filtered), err
+		}
+	}
+}
+
+func (w *xonXoffReadWriter) setPaused(paused bool) {
+	w.mu.Lock()
+	w.paused = paused
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// Write blocks in chunks while paused by a received XOFF, resuming as soon as
+// an XON arrives. If the wrapper is closed while paused, Write stops and
+// returns the number of bytes written so far with an error.
+func (w *xonXoffReadWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > xonXoffWriteChunk {
+			chunk = chunk[:xonXoffWriteChunk]
+		}
+
+		w.mu.Lock()
+		for w.paused && !w.closed {
+			w.cond.Wait()
+		}
+		closed := w.closed
+		w.mu.Unlock()
+		if closed {
+			return written, fmt.Errorf("port closed while paused by XOFF")
+		}
+
+		n, err := w.Port.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close releases any Write call currently blocked on a pending XOFF. It does
+// not close the underlying port, which remains owned by SerialMonitor.
+func (w *xonXoffReadWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return nil
+}
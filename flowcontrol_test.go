@@ -0,0 +1,123 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// fakeSerialPort is a minimal serial.Port backed by a queue of canned Read
+// results, so flow-control logic can be tested without real hardware.
+type fakeSerialPort struct {
+	reads [][]byte
+}
+
+func (p *fakeSerialPort) Read(b []byte) (int, error) {
+	if len(p.reads) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.reads[0])
+	p.reads = p.reads[1:]
+	return n, nil
+}
+
+func (p *fakeSerialPort) Write(b []byte) (int, error) { return len(b), nil }
+func (p *fakeSerialPort) SetMode(*serial.Mode) error  { return nil }
+func (p *fakeSerialPort) Drain() error                { return nil }
+func (p *fakeSerialPort) ResetInputBuffer() error     { return nil }
+func (p *fakeSerialPort) ResetOutputBuffer() error    { return nil }
+func (p *fakeSerialPort) SetDTR(bool) error           { return nil }
+func (p *fakeSerialPort) SetRTS(bool) error           { return nil }
+func (p *fakeSerialPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+func (p *fakeSerialPort) SetReadTimeout(time.Duration) error { return nil }
+func (p *fakeSerialPort) Close() error                       { return nil }
+func (p *fakeSerialPort) Break(time.Duration) error          { return nil }
+
+func TestXonXoffReadWriterStripsControlBytes(t *testing.T) {
+	port := &fakeSerialPort{reads: [][]byte{{'A', xonByte, 'B', xoffByte, 'C'}}}
+	w := newXonXoffReadWriter(port)
+
+	buf := make([]byte, 16)
+	n, err := w.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if got := string(buf[:n]); got != "ABC" {
+		t.Fatalf("Read = %q, want %q", got, "ABC")
+	}
+}
+
+func TestXonXoffReadWriterAllControlBytesDoesNotReturnZeroNil(t *testing.T) {
+	port := &fakeSerialPort{reads: [][]byte{
+		{xoffByte, xonByte, xoffByte},
+		{'D'},
+	}}
+	w := newXonXoffReadWriter(port)
+
+	buf := make([]byte, 16)
+	done := make(chan struct{})
+	var n int
+	var err error
+	go func() {
+		n, err = w.Read(buf)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Read never returned after an all-control-byte chunk; looks like it returned (0, nil) instead of retrying internally")
+	}
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if got := string(buf[:n]); got != "D" {
+		t.Fatalf("Read = %q, want %q", got, "D")
+	}
+}
+
+func TestXonXoffReadWriterPausesAndResumesWrites(t *testing.T) {
+	port := &fakeSerialPort{}
+	w := newXonXoffReadWriter(port)
+
+	w.setPaused(true)
+	done := make(chan struct{})
+	go func() {
+		_, _ = w.Write([]byte("hello"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Write returned while paused by XOFF")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	w.setPaused(false)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write did not resume after XON")
+	}
+}
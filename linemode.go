@@ -0,0 +1,261 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// readWriter combines a separately-wrapped io.Reader and io.Writer into a
+// single io.ReadWriter, the way the stdlib's io.MultiWriter-adjacent helpers
+// do for similar compositions.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func (d *SerialMonitor) readTimeout() time.Duration {
+	ms, err := strconv.Atoi(d.serialSettings.ConfigurationParameter["read_timeout_ms"].Selected)
+	if err != nil || ms <= 0 {
+		return serial.NoTimeout
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func (d *SerialMonitor) interbyteTimeout() time.Duration {
+	ms, err := strconv.Atoi(d.serialSettings.ConfigurationParameter["interbyte_timeout_ms"].Selected)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func lineEndingBytes(ending string) []byte {
+	switch ending {
+	case "lf":
+		return []byte("\n")
+	case "cr":
+		return []byte("\r")
+	case "crlf":
+		return []byte("\r\n")
+	default: // "none"
+		return nil
+	}
+}
+
+// lineEndingWriter rewrites every newline written through it to the
+// configured line ending, normalizing CR, LF and CRLF in the input first so
+// callers can always write plain "\n". A trailing "\r" is held back across
+// calls to Write rather than translated immediately, since the caller isn't
+// guaranteed to deliver a "\r\n" pair within a single Write.
+type lineEndingWriter struct {
+	io.Writer
+	ending []byte
+
+	pendingCR bool
+}
+
+func newLineEndingWriter(w io.Writer, ending string) io.Writer {
+	seq := lineEndingBytes(ending)
+	if seq == nil {
+		return w
+	}
+	return &lineEndingWriter{Writer: w, ending: seq}
+}
+
+func (w *lineEndingWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	buf := p
+	if w.pendingCR {
+		// The previous Write ended in a bare '\r'. Whether that was half of
+		// a "\r\n" pair split across the two Writes, or a standalone '\r',
+		// depends on this call's leading byte.
+		w.pendingCR = false
+		if buf[0] == '\n' {
+			buf = buf[1:]
+		}
+		if _, err := w.Writer.Write(w.ending); err != nil {
+			return 0, err
+		}
+		if len(buf) == 0 {
+			return len(p), nil
+		}
+	}
+
+	trailingCR := buf[len(buf)-1] == '\r'
+	if trailingCR {
+		buf = buf[:len(buf)-1]
+	}
+
+	if len(buf) > 0 {
+		var err error
+		if bytes.ContainsAny(buf, "\r\n") {
+			normalized := bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))
+			normalized = bytes.ReplaceAll(normalized, []byte("\r"), []byte("\n"))
+			translated := bytes.ReplaceAll(normalized, []byte("\n"), w.ending)
+			_, err = w.Writer.Write(translated)
+		} else {
+			_, err = w.Writer.Write(buf)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if trailingCR {
+		w.pendingCR = true
+	}
+	// The caller only knows about its own p, so report it as fully written.
+	return len(p), nil
+}
+
+// interbyteReader wraps an io.Reader so that, once the first byte of a read
+// has arrived, it returns early after `timeout` of inactivity instead of
+// blocking until the caller's buffer is full. This gives line-oriented
+// protocols (AT commands, Gcode, NMEA) responsive line-at-a-time reads.
+type interbyteReader struct {
+	timeout time.Duration
+	chunks  chan []byte
+	quit    chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	pending []byte
+	err     error
+}
+
+func newInterbyteReader(src io.Reader, timeout time.Duration) *interbyteReader {
+	r := &interbyteReader{
+		timeout: timeout,
+		chunks:  make(chan []byte),
+		quit:    make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.pump(src)
+	return r
+}
+
+func (r *interbyteReader) pump(src io.Reader) {
+	defer r.wg.Done()
+	defer close(r.chunks)
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-r.quit:
+			return
+		default:
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			select {
+			case r.chunks <- chunk:
+			case <-r.quit:
+				return
+			}
+		}
+		if err != nil {
+			r.mu.Lock()
+			r.err = err
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Close signals the pump goroutine to stop and waits for it to exit. Since
+// src.Read can be blocked indefinitely (e.g. read_timeout_ms == 0), this
+// only returns promptly if the caller has already closed (or is concurrently
+// closing) the underlying port so that the blocked Read unblocks with an
+// error; SerialMonitor.Close does this before calling interbyteCloser.Close.
+func (r *interbyteReader) Close() error {
+	close(r.quit)
+	r.wg.Wait()
+	return nil
+}
+
+func (r *interbyteReader) takeErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	return io.EOF
+}
+
+// Read blocks until at least one byte is available, then keeps appending
+// further bytes as long as they keep arriving within `timeout` of each
+// other, returning as soon as p is full or a quiet gap is observed.
+func (r *interbyteReader) Read(p []byte) (int, error) {
+	n := 0
+
+	r.mu.Lock()
+	if len(r.pending) > 0 {
+		n = copy(p, r.pending)
+		r.pending = r.pending[n:]
+	}
+	r.mu.Unlock()
+
+	if n == 0 {
+		chunk, ok := <-r.chunks
+		if !ok {
+			return 0, r.takeErr()
+		}
+		n = copy(p, chunk)
+		if n < len(chunk) {
+			r.mu.Lock()
+			r.pending = append(r.pending, chunk[n:]...)
+			r.mu.Unlock()
+		}
+	}
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	for n < len(p) {
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				return n, nil
+			}
+			copied := copy(p[n:], chunk)
+			n += copied
+			if copied < len(chunk) {
+				r.mu.Lock()
+				r.pending = append(r.pending, chunk[copied:]...)
+				r.mu.Unlock()
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(r.timeout)
+		case <-timer.C:
+			return n, nil
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLineEndingWriterTranslatesWithinOneWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := newLineEndingWriter(&buf, "crlf")
+
+	if _, err := w.Write([]byte("line one\r\nline two\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	want := "line one\r\nline two\r\n"
+	if buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestLineEndingWriterCarriesDanglingCRAcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := newLineEndingWriter(&buf, "lf")
+
+	if _, err := w.Write([]byte("line one\r")); err != nil {
+		t.Fatalf("first Write returned error: %v", err)
+	}
+	if _, err := w.Write([]byte("\nline two")); err != nil {
+		t.Fatalf("second Write returned error: %v", err)
+	}
+	want := "line one\nline two"
+	if buf.String() != want {
+		t.Fatalf("a \"\\r\\n\" split across two Writes produced %q, want %q (likely double-translated)", buf.String(), want)
+	}
+}
+
+func TestLineEndingWriterStandaloneCRAcrossWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := newLineEndingWriter(&buf, "lf")
+
+	if _, err := w.Write([]byte("line one\r")); err != nil {
+		t.Fatalf("first Write returned error: %v", err)
+	}
+	if _, err := w.Write([]byte("line two")); err != nil {
+		t.Fatalf("second Write returned error: %v", err)
+	}
+	want := "line one\nline two"
+	if buf.String() != want {
+		t.Fatalf("a standalone trailing \\r not followed by \\n got %q, want %q", buf.String(), want)
+	}
+}
+
+// chunkReader feeds back one queued []byte (or error) per Read call,
+// blocking until the next one is sent, so interbyteReader's pump can be
+// driven deterministically without a real serial port.
+type chunkReader struct {
+	chunks  chan []byte
+	errs    chan error
+	entered chan struct{}
+}
+
+func newChunkReader() *chunkReader {
+	return &chunkReader{
+		chunks:  make(chan []byte),
+		errs:    make(chan error),
+		entered: make(chan struct{}, 1),
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	select {
+	case r.entered <- struct{}{}:
+	default:
+	}
+	select {
+	case c := <-r.chunks:
+		return copy(p, c), nil
+	case err := <-r.errs:
+		return 0, err
+	}
+}
+
+func TestInterbyteReaderReturnsEarlyAfterQuietGap(t *testing.T) {
+	src := newChunkReader()
+	r := newInterbyteReader(src, 20*time.Millisecond)
+	defer func() {
+		src.errs <- io.EOF
+		r.Close()
+	}()
+
+	src.chunks <- []byte("AB")
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if got := string(buf[:n]); got != "AB" {
+		t.Fatalf("Read = %q, want %q", got, "AB")
+	}
+}
+
+func TestInterbyteReaderCoalescesBytesWithinTimeout(t *testing.T) {
+	src := newChunkReader()
+	r := newInterbyteReader(src, 200*time.Millisecond)
+	defer func() {
+		src.errs <- io.EOF
+		r.Close()
+	}()
+
+	go func() {
+		src.chunks <- []byte("A")
+		time.Sleep(10 * time.Millisecond)
+		src.chunks <- []byte("B")
+		time.Sleep(10 * time.Millisecond)
+		src.chunks <- []byte("C")
+	}()
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil {
+		t.Fatalf("Read returned error: %v", err)
+	}
+	if got := string(buf[:n]); got != "ABC" {
+		t.Fatalf("Read = %q, want %q (bytes arriving within the interbyte timeout should coalesce)", got, "ABC")
+	}
+}
+
+func TestInterbyteReaderCloseWaitsForPumpToExit(t *testing.T) {
+	src := newChunkReader()
+	r := newInterbyteReader(src, 20*time.Millisecond)
+
+	// Make sure the pump is actually blocked in src.Read before Close is
+	// invoked, so Close has no choice but to wait for the error below.
+	<-src.entered
+
+	done := make(chan struct{})
+	go func() {
+		_ = r.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Close returned before the pump's blocked Read was unblocked")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	src.errs <- errors.New("port closed")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not return after the underlying Read errored out")
+	}
+}
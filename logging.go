@@ -0,0 +1,225 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultLogRotateBytes is used when log_rotate_bytes is unset or invalid.
+const defaultLogRotateBytes = 10 * 1024 * 1024
+
+// teeReadWriter wraps an io.ReadWriter, duplicating every byte read from or
+// written to it into an on-disk capture file formatted per format
+// ("raw", "timestamped" or "hex"). It is safe for concurrent Read/Write,
+// which is how the pluggable-monitor-protocol-handler drives it.
+type teeReadWriter struct {
+	io.ReadWriter
+
+	path        string
+	format      string
+	rotateBytes int64
+
+	mu          sync.Mutex
+	file        *os.File
+	writer      *bufio.Writer
+	written     int64
+	rotateIndex int
+
+	rxLineBuf, txLineBuf []byte
+	rxHexBuf, txHexBuf   []byte
+	rxHexOffset          int64
+	txHexOffset          int64
+}
+
+// newTeeReadWriter opens path (creating/appending to it) and returns a
+// teeReadWriter that captures everything flowing through rw into it.
+func newTeeReadWriter(rw io.ReadWriter, path, format string, rotateBytes int64) (*teeReadWriter, error) {
+	t := &teeReadWriter{
+		ReadWriter:  rw,
+		path:        path,
+		format:      format,
+		rotateBytes: rotateBytes,
+	}
+	if err := t.openLogFile(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *teeReadWriter) openLogFile() error {
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log_path %s: %w", t.path, err)
+	}
+	if info, statErr := f.Stat(); statErr == nil {
+		t.written = info.Size()
+	}
+	t.file = f
+	t.writer = bufio.NewWriter(f)
+	return nil
+}
+
+// Read forwards to the wrapped ReadWriter, teeing received bytes to the log.
+func (t *teeReadWriter) Read(p []byte) (int, error) {
+	n, err := t.ReadWriter.Read(p)
+	if n > 0 {
+		t.mu.Lock()
+		t.log("RX", p[:n])
+		t.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write forwards to the wrapped ReadWriter, teeing sent bytes to the log.
+func (t *teeReadWriter) Write(p []byte) (int, error) {
+	n, err := t.ReadWriter.Write(p)
+	if n > 0 {
+		t.mu.Lock()
+		t.log("TX", p[:n])
+		t.mu.Unlock()
+	}
+	return n, err
+}
+
+// log must be called with t.mu held.
+func (t *teeReadWriter) log(direction string, p []byte) {
+	switch t.format {
+	case "hex":
+		buf, offset := t.hexState(direction)
+		*buf, *offset = t.appendHex(*buf, *offset, p)
+	case "timestamped":
+		buf := t.lineState(direction)
+		*buf = t.appendTimestamped(*buf, direction, p)
+	default: // "raw"
+		t.writeOut(p)
+	}
+	if t.rotateBytes > 0 && t.written >= t.rotateBytes {
+		t.rotate()
+	}
+}
+
+func (t *teeReadWriter) lineState(direction string) *[]byte {
+	if direction == "RX" {
+		return &t.rxLineBuf
+	}
+	return &t.txLineBuf
+}
+
+func (t *teeReadWriter) hexState(direction string) (*[]byte, *int64) {
+	if direction == "RX" {
+		return &t.rxHexBuf, &t.rxHexOffset
+	}
+	return &t.txHexBuf, &t.txHexOffset
+}
+
+// appendTimestamped buffers p (after CR/LF normalization) and flushes every
+// complete line prefixed with an RFC3339 timestamp and direction tag,
+// returning the unflushed remainder.
+func (t *teeReadWriter) appendTimestamped(buf []byte, direction string, p []byte) []byte {
+	normalized := bytes.ReplaceAll(p, []byte("\r\n"), []byte("\n"))
+	normalized = bytes.ReplaceAll(normalized, []byte("\r"), []byte("\n"))
+	buf = append(buf, normalized...)
+	for {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			break
+		}
+		t.writeOut([]byte(fmt.Sprintf("%s [%s] %s\n", time.Now().Format(time.RFC3339Nano), direction, buf[:idx])))
+		buf = buf[idx+1:]
+	}
+	return buf
+}
+
+// appendHex buffers p and flushes every complete 16-byte row in canonical
+// xxd-style "offset  hex bytes  |ascii|" form, returning the unflushed
+// remainder and the offset to resume from.
+func (t *teeReadWriter) appendHex(buf []byte, offset int64, p []byte) ([]byte, int64) {
+	buf = append(buf, p...)
+	for len(buf) >= 16 {
+		t.writeOut([]byte(hexDumpRow(offset, buf[:16])))
+		buf = buf[16:]
+		offset += 16
+	}
+	return buf, offset
+}
+
+func hexDumpRow(offset int64, row []byte) string {
+	var hexPart bytes.Buffer
+	var asciiPart bytes.Buffer
+	for i, b := range row {
+		fmt.Fprintf(&hexPart, "%02x ", b)
+		if i == 7 {
+			hexPart.WriteByte(' ')
+		}
+		if b >= 0x20 && b < 0x7f {
+			asciiPart.WriteByte(b)
+		} else {
+			asciiPart.WriteByte('.')
+		}
+	}
+	return fmt.Sprintf("%08x  %s |%s|\n", offset, hexPart.String(), asciiPart.String())
+}
+
+func (t *teeReadWriter) writeOut(p []byte) {
+	n, _ := t.writer.Write(p)
+	t.written += int64(n)
+}
+
+// rotate is called with t.mu held once the log file reaches rotateBytes: the
+// current file is closed and renamed aside, and a fresh one is opened at
+// t.path so logging continues uninterrupted.
+func (t *teeReadWriter) rotate() {
+	_ = t.writer.Flush()
+	_ = t.file.Close()
+	t.rotateIndex++
+	_ = os.Rename(t.path, fmt.Sprintf("%s.%d", t.path, t.rotateIndex))
+	t.written = 0
+	if err := t.openLogFile(); err != nil {
+		// Nothing sensible to do with a rotation failure other than stop
+		// trying to log further data until the next Open.
+		t.writer = bufio.NewWriter(io.Discard)
+	}
+}
+
+// Close flushes any buffered partial lines/rows and closes the log file. It
+// does not close the underlying port, which remains owned by SerialMonitor.
+func (t *teeReadWriter) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.rxLineBuf) > 0 {
+		t.writeOut([]byte(fmt.Sprintf("%s [RX] %s\n", time.Now().Format(time.RFC3339Nano), t.rxLineBuf)))
+	}
+	if len(t.txLineBuf) > 0 {
+		t.writeOut([]byte(fmt.Sprintf("%s [TX] %s\n", time.Now().Format(time.RFC3339Nano), t.txLineBuf)))
+	}
+	if len(t.rxHexBuf) > 0 {
+		t.writeOut([]byte(hexDumpRow(t.rxHexOffset, t.rxHexBuf)))
+	}
+	if len(t.txHexBuf) > 0 {
+		t.writeOut([]byte(hexDumpRow(t.txHexOffset, t.txHexBuf)))
+	}
+	t.writer.Flush()
+	return t.file.Close()
+}
@@ -0,0 +1,101 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHexDumpRow(t *testing.T) {
+	row := []byte("ABCDEFGHIJKLMNOP")
+	got := hexDumpRow(0x10, row)
+	want := "00000010  41 42 43 44 45 46 47 48  49 4a 4b 4c 4d 4e 4f 50  |ABCDEFGHIJKLMNOP|\n"
+	if got != want {
+		t.Fatalf("hexDumpRow =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestHexDumpRowNonPrintable(t *testing.T) {
+	row := []byte{0x00, 0x01, 'A', 0x7f, 0xff}
+	got := hexDumpRow(0, row)
+	if !strings.Contains(got, "00 01 41 7f ff") {
+		t.Fatalf("hexDumpRow hex part wrong: %q", got)
+	}
+	if !strings.Contains(got, "|..A..|") {
+		t.Fatalf("hexDumpRow ascii part wrong: %q", got)
+	}
+}
+
+func newTestTeeReadWriter(format string) (*teeReadWriter, *bytes.Buffer) {
+	var buf bytes.Buffer
+	t := &teeReadWriter{format: format, writer: bufio.NewWriter(&buf)}
+	return t, &buf
+}
+
+func TestAppendHexBuffersPartialRow(t *testing.T) {
+	tee, buf := newTestTeeReadWriter("hex")
+
+	rxBuf, rxOffset := tee.hexState("RX")
+	*rxBuf, *rxOffset = tee.appendHex(*rxBuf, *rxOffset, []byte("short"))
+	_ = tee.writer.Flush()
+	if buf.Len() != 0 {
+		t.Fatalf("appendHex flushed a row before 16 bytes accumulated: %q", buf.String())
+	}
+	if len(*rxBuf) != len("short") {
+		t.Fatalf("appendHex dropped buffered bytes: got %d want %d", len(*rxBuf), len("short"))
+	}
+
+	rxBuf, rxOffset = tee.hexState("RX")
+	*rxBuf, *rxOffset = tee.appendHex(*rxBuf, *rxOffset, []byte("er but still not 16"))
+	_ = tee.writer.Flush()
+	if buf.Len() == 0 {
+		t.Fatal("appendHex did not flush once 16 bytes were available")
+	}
+	if !strings.HasPrefix(buf.String(), "00000000  ") {
+		t.Fatalf("appendHex row offset wrong: %q", buf.String())
+	}
+}
+
+func TestAppendTimestampedFlushesCompleteLinesOnly(t *testing.T) {
+	tee, buf := newTestTeeReadWriter("timestamped")
+
+	rest := tee.appendTimestamped(nil, "RX", []byte("partial line, no newline yet"))
+	_ = tee.writer.Flush()
+	if buf.Len() != 0 {
+		t.Fatalf("appendTimestamped flushed before a newline was seen: %q", buf.String())
+	}
+	if string(rest) != "partial line, no newline yet" {
+		t.Fatalf("appendTimestamped buffer = %q", rest)
+	}
+
+	rest = tee.appendTimestamped(rest, "RX", []byte("\r\nsecond line, still incomplete"))
+	_ = tee.writer.Flush()
+	out := buf.String()
+	if !strings.Contains(out, "[RX] partial line, no newline yet\n") {
+		t.Fatalf("appendTimestamped did not flush the completed line: %q", out)
+	}
+	if strings.Count(out, "[RX]") != 1 {
+		t.Fatalf("appendTimestamped flushed the wrong number of lines: %q", out)
+	}
+	if string(rest) != "second line, still incomplete" {
+		t.Fatalf("appendTimestamped left remainder = %q, want %q", rest, "second line, still incomplete")
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
 
 	monitor "github.com/arduino/pluggable-monitor-protocol-handler"
 	"github.com/arduino/serial-monitor/args"
@@ -31,6 +32,18 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// Sane bounds for the free-text "baudrate" parameter: below minBaudRate most
+// UARTs can't hold a stable clock, above maxBaudRate go.bug.st/serial's
+// cross-platform support becomes unreliable.
+const (
+	minBaudRate = 50
+	maxBaudRate = 4000000
+)
+
+// minStatusPollMs bounds how aggressively the modem-status line poller
+// defined in modemstatus.go is allowed to run.
+const minStatusPollMs = 20
+
 func main() {
 	args.Parse()
 	if args.ShowVersion {
@@ -50,6 +63,32 @@ type SerialMonitor struct {
 	serialPort     serial.Port
 	serialSettings *monitor.PortDescriptor
 	openedPort     bool
+
+	// settingsMu guards the "cts"/"dsr"/"ri"/"dcd" and "status_poll_ms"
+	// entries of serialSettings.ConfigurationParameter, which the
+	// modem-status polling goroutine (modemstatus.go) reads and writes
+	// concurrently with Configure/Describe running on the handler's
+	// goroutine.
+	settingsMu sync.Mutex
+
+	// flowControlCloser tears down the goroutine/buffer backing the
+	// software flow-control wrapper returned by Open, if any is active.
+	flowControlCloser io.Closer
+
+	// interbyteCloser stops the pump goroutine backing the interbyteReader
+	// set up by Open when interbyte_timeout_ms is non-zero, if any is
+	// active.
+	interbyteCloser io.Closer
+
+	// logCloser flushes and closes the session log file set up by Open
+	// when log_path is non-empty.
+	logCloser io.Closer
+
+	// modemStatusQuit, when non-nil, signals the modem-status polling
+	// goroutine started by Open to stop; modemStatusWg is used to wait
+	// for it to actually exit.
+	modemStatusQuit chan struct{}
+	modemStatusWg   sync.WaitGroup
 }
 
 // NewSerialMonitor will initialize and return a SerialMonitor
@@ -59,8 +98,11 @@ func NewSerialMonitor() *SerialMonitor {
 			Protocol: "serial",
 			ConfigurationParameter: map[string]*monitor.PortParameterDescriptor{
 				"baudrate": {
+					// Type "int" accepts any integer from minBaudRate to
+					// maxBaudRate (checked in Configure), not only the entries
+					// of Values, which is a list of common presets for the UI.
 					Label: "Baudrate",
-					Type:  "enum",
+					Type:  "int",
 					Values: []string{
 						"300", "600", "750",
 						"1200", "2400", "4800", "9600",
@@ -99,6 +141,84 @@ func NewSerialMonitor() *SerialMonitor {
 					Values:   []string{"on", "off"},
 					Selected: "on",
 				},
+				"flow_control": {
+					Label:    "Flow control",
+					Type:     "enum",
+					Values:   []string{"none", "rts_cts", "xon_xoff"},
+					Selected: "none",
+				},
+				"status_poll_ms": {
+					Label:    "Modem status poll interval (ms)",
+					Type:     "int",
+					Selected: "250",
+				},
+				"cts": {
+					Label:    "CTS",
+					Type:     "readonly",
+					Values:   []string{"on", "off"},
+					Selected: "off",
+				},
+				"dsr": {
+					Label:    "DSR",
+					Type:     "readonly",
+					Values:   []string{"on", "off"},
+					Selected: "off",
+				},
+				"ri": {
+					Label:    "RI",
+					Type:     "readonly",
+					Values:   []string{"on", "off"},
+					Selected: "off",
+				},
+				"dcd": {
+					Label:    "DCD",
+					Type:     "readonly",
+					Values:   []string{"on", "off"},
+					Selected: "off",
+				},
+				"bootloader_reset": {
+					Label:    "Reset to bootloader before opening (1200bps touch)",
+					Type:     "enum",
+					Values:   []string{"on", "off"},
+					Selected: "off",
+				},
+				"bootloader_enum_timeout_ms": {
+					Label:    "Bootloader re-enumeration timeout (ms)",
+					Type:     "int",
+					Selected: "3000",
+				},
+				"log_path": {
+					Label:    "Session log file",
+					Type:     "text",
+					Selected: "",
+				},
+				"log_format": {
+					Label:    "Session log format",
+					Type:     "enum",
+					Values:   []string{"raw", "timestamped", "hex"},
+					Selected: "raw",
+				},
+				"log_rotate_bytes": {
+					Label:    "Session log rotation size (bytes)",
+					Type:     "int",
+					Selected: strconv.Itoa(defaultLogRotateBytes),
+				},
+				"line_ending": {
+					Label:    "Outgoing line ending",
+					Type:     "enum",
+					Values:   []string{"none", "lf", "cr", "crlf"},
+					Selected: "none",
+				},
+				"read_timeout_ms": {
+					Label:    "Read timeout (ms, 0 = blocking)",
+					Type:     "int",
+					Selected: "0",
+				},
+				"interbyte_timeout_ms": {
+					Label:    "Inter-byte read timeout (ms, 0 = disabled)",
+					Type:     "int",
+					Selected: "0",
+				},
 			},
 		},
 		openedPort: false,
@@ -112,7 +232,24 @@ func (d *SerialMonitor) Hello(userAgent string, protocol int) error {
 
 // Describe is the handler for the pluggable-monitor DESCRIBE command
 func (d *SerialMonitor) Describe() (*monitor.PortDescriptor, error) {
-	return d.serialSettings, nil
+	// serialSettings.ConfigurationParameter entries are returned to the
+	// caller for serialization after this function returns, at which
+	// point the modem-status poller could still be mutating the
+	// readonly status parameters. Return a snapshot taken under
+	// settingsMu instead of the live map so the caller never observes a
+	// parameter changing out from under it.
+	d.settingsMu.Lock()
+	defer d.settingsMu.Unlock()
+
+	snapshot := &monitor.PortDescriptor{
+		Protocol:               d.serialSettings.Protocol,
+		ConfigurationParameter: make(map[string]*monitor.PortParameterDescriptor, len(d.serialSettings.ConfigurationParameter)),
+	}
+	for name, parameter := range d.serialSettings.ConfigurationParameter {
+		copied := *parameter
+		snapshot.ConfigurationParameter[name] = &copied
+	}
+	return snapshot, nil
 }
 
 // Configure is the handler for the pluggable-monitor CONFIGURE command
@@ -121,12 +258,49 @@ func (d *SerialMonitor) Configure(parameterName string, value string) error {
 	if !ok {
 		return fmt.Errorf("could not find parameter named %s", parameterName)
 	}
-	if !slices.Contains(parameter.Values, value) {
+	if parameter.Type == "readonly" {
+		return fmt.Errorf("parameter %s is read-only", parameterName)
+	}
+	if parameter.Type == "enum" && !slices.Contains(parameter.Values, value) {
 		return fmt.Errorf("invalid value for parameter %s: %s", parameterName, value)
 	}
-	// Set configuration
+	if parameterName == "baudrate" {
+		baud, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid baudrate value: %s", value)
+		}
+		if baud < minBaudRate || baud > maxBaudRate {
+			return fmt.Errorf("baudrate out of range (%d-%d): %d", minBaudRate, maxBaudRate, baud)
+		}
+	}
+	if parameterName == "status_poll_ms" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < minStatusPollMs {
+			return fmt.Errorf("invalid status_poll_ms value: %s", value)
+		}
+	}
+	if parameterName == "bootloader_enum_timeout_ms" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid bootloader_enum_timeout_ms value: %s", value)
+		}
+	}
+	if parameterName == "log_rotate_bytes" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid log_rotate_bytes value: %s", value)
+		}
+	}
+	if parameterName == "read_timeout_ms" || parameterName == "interbyte_timeout_ms" {
+		if ms, err := strconv.Atoi(value); err != nil || ms < 0 {
+			return fmt.Errorf("invalid %s value: %s", parameterName, value)
+		}
+	}
+	// Set configuration. Guarded by settingsMu since status_poll_ms is also
+	// read by the modem-status poller goroutine.
+	d.settingsMu.Lock()
 	oldValue := parameter.Selected
 	parameter.Selected = value
+	d.settingsMu.Unlock()
 
 	// Apply configuration to port
 	var configErr error
@@ -138,6 +312,20 @@ func (d *SerialMonitor) Configure(parameterName string, value string) error {
 			configErr = d.serialPort.SetDTR(d.getDTR())
 		case "rts":
 			configErr = d.serialPort.SetRTS(d.getRTS())
+		case "flow_control":
+			// Takes effect on the next Open; the active ReadWriter
+			// wrapper can't be swapped out while the port is in use.
+		case "status_poll_ms":
+			configErr = d.restartModemStatusPolling()
+		case "bootloader_reset", "bootloader_enum_timeout_ms":
+			// Only consulted at the start of the next Open.
+		case "log_path", "log_format", "log_rotate_bytes":
+			// The tee wrapper is only set up once, in Open; changes take
+			// effect the next time the port is opened.
+		case "line_ending", "interbyte_timeout_ms":
+			// The wrapping reader/writer is only set up once, in Open.
+		case "read_timeout_ms":
+			configErr = d.serialPort.SetReadTimeout(d.readTimeout())
 		default:
 			// Should never happen
 			panic("Invalid parameter: " + parameterName)
@@ -146,7 +334,9 @@ func (d *SerialMonitor) Configure(parameterName string, value string) error {
 
 	// If configuration failed, rollback settings
 	if configErr != nil {
+		d.settingsMu.Lock()
 		parameter.Selected = oldValue
+		d.settingsMu.Unlock()
 		return configErr
 	}
 	return nil
@@ -157,6 +347,18 @@ func (d *SerialMonitor) Open(boardPort string) (io.ReadWriter, error) {
 	if d.openedPort {
 		return nil, fmt.Errorf("port already opened: %s", boardPort)
 	}
+
+	if d.serialSettings.ConfigurationParameter["bootloader_reset"].Selected == "on" {
+		// The board may re-enumerate under a different device node than
+		// boardPort, so open whatever port touchForBootloader reports.
+		newPort, err := touchForBootloader(boardPort, d.bootloaderEnumTimeout())
+		if err != nil {
+			return nil, err
+		}
+		boardPort = newPort
+		d.serialSettings.ConfigurationParameter["bootloader_reset"].Selected = "off"
+	}
+
 	serialPort, err := serial.Open(boardPort, d.getMode())
 	if err != nil {
 		return nil, err
@@ -166,9 +368,79 @@ func (d *SerialMonitor) Open(boardPort string) (io.ReadWriter, error) {
 	_ = serialPort.ResetInputBuffer() // do not error if resetting buffers fails
 	_ = serialPort.ResetOutputBuffer()
 
+	if err := serialPort.SetReadTimeout(d.readTimeout()); err != nil {
+		serialPort.Close()
+		return nil, err
+	}
+
+	var flowControlCloser io.Closer
+	var rw io.ReadWriter = serialPort
+	switch d.serialSettings.ConfigurationParameter["flow_control"].Selected {
+	case "rts_cts":
+		w := newRTSCTSReadWriter(serialPort)
+		flowControlCloser = w
+		rw = w
+	case "xon_xoff":
+		w := newXonXoffReadWriter(serialPort)
+		flowControlCloser = w
+		rw = w
+	}
+
+	var interbyteCloser io.Closer
+	lineEnding := d.serialSettings.ConfigurationParameter["line_ending"].Selected
+	interbyteTimeout := d.interbyteTimeout()
+	if lineEnding != "none" || interbyteTimeout > 0 {
+		var r io.Reader = rw
+		if interbyteTimeout > 0 {
+			ir := newInterbyteReader(rw, interbyteTimeout)
+			interbyteCloser = ir
+			r = ir
+		}
+		var w io.Writer = rw
+		if lineEnding != "none" {
+			w = newLineEndingWriter(rw, lineEnding)
+		}
+		rw = &readWriter{Reader: r, Writer: w}
+	}
+
+	var logCloser io.Closer
+	if logPath := d.serialSettings.ConfigurationParameter["log_path"].Selected; logPath != "" {
+		rotateBytes, err := strconv.ParseInt(d.serialSettings.ConfigurationParameter["log_rotate_bytes"].Selected, 10, 64)
+		if err != nil {
+			rotateBytes = defaultLogRotateBytes
+		}
+		format := d.serialSettings.ConfigurationParameter["log_format"].Selected
+		t, err := newTeeReadWriter(rw, logPath, format, rotateBytes)
+		if err != nil {
+			// Close the port first: interbyteCloser's pump goroutine is
+			// blocked on a Read through it and only unblocks once the
+			// port itself errors out.
+			serialPort.Close()
+			if interbyteCloser != nil {
+				_ = interbyteCloser.Close()
+			}
+			if flowControlCloser != nil {
+				_ = flowControlCloser.Close()
+			}
+			return nil, err
+		}
+		logCloser = t
+		rw = t
+	}
+
+	// Nothing past this point is fallible, so only now commit the new state:
+	// if Open returned an error above, the caller believes the port was
+	// never opened and will never call Close, so d.openedPort/d.serialPort
+	// must stay untouched until setup can no longer fail.
 	d.openedPort = true
 	d.serialPort = serialPort
-	return d.serialPort, nil
+	d.flowControlCloser = flowControlCloser
+	d.interbyteCloser = interbyteCloser
+	d.logCloser = logCloser
+
+	d.startModemStatusPolling()
+
+	return rw, nil
 }
 
 // Close is the handler for the pluggable-monitor CLOSE command
@@ -176,13 +448,37 @@ func (d *SerialMonitor) Close() error {
 	if !d.openedPort {
 		return errors.New("port already closed")
 	}
+	d.stopModemStatusPolling()
+	if d.logCloser != nil {
+		_ = d.logCloser.Close()
+		d.logCloser = nil
+	}
+	// Close the port before interbyteCloser: its pump goroutine is blocked
+	// on a Read through the port (possibly via flowControlCloser's wrapper)
+	// and only unblocks once the port itself errors out.
 	d.serialPort.Close()
+	if d.interbyteCloser != nil {
+		_ = d.interbyteCloser.Close()
+		d.interbyteCloser = nil
+	}
+	if d.flowControlCloser != nil {
+		_ = d.flowControlCloser.Close()
+		d.flowControlCloser = nil
+	}
 	d.openedPort = false
 	return nil
 }
 
 // Quit is the handler for the pluggable-monitor QUIT command
-func (d *SerialMonitor) Quit() {}
+func (d *SerialMonitor) Quit() {
+	// QUIT is handled directly by the protocol handler and isn't preceded
+	// by an automatic CLOSE, so if the port is still open this is the last
+	// chance to flush the session log and release the port and its
+	// background goroutines before the process exits.
+	if d.openedPort {
+		_ = d.Close()
+	}
+}
 
 func (d *SerialMonitor) getMode() *serial.Mode {
 	baud, _ := strconv.Atoi(d.serialSettings.ConfigurationParameter["baudrate"].Selected)
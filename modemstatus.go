@@ -0,0 +1,111 @@
+//
+// This file is part of serial-monitor.
+//
+// Copyright 2018-2021 ARDUINO SA (http://www.arduino.cc/)
+//
+// This software is released under the GNU General Public License version 3,
+// which covers the main part of arduino-cli.
+// The terms of this license can be found at:
+// https://www.gnu.org/licenses/gpl-3.0.en.html
+//
+// You can be released from the requirements of the above licenses by purchasing
+// a commercial license. Buying such a license is mandatory if you want to modify or
+// otherwise use the software for commercial activities involving the Arduino
+// software without disclosing the source code of your own applications. To purchase
+// a commercial license, send an email to license@arduino.cc.
+//
+
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// startModemStatusPolling launches a goroutine that periodically samples
+// CTS/DSR/RI/DCD and reflects their state into the read-only "cts"/"dsr"/
+// "ri"/"dcd" configuration parameters, so the IDE can observe them with a
+// plain Describe call instead of requiring a push-notification capability.
+func (d *SerialMonitor) startModemStatusPolling() {
+	d.modemStatusQuit = make(chan struct{})
+	d.modemStatusWg.Add(1)
+	go d.pollModemStatus(d.modemStatusQuit)
+}
+
+// stopModemStatusPolling stops the goroutine started by
+// startModemStatusPolling, if any, and waits for it to exit. It is safe to
+// call even if polling was never started.
+func (d *SerialMonitor) stopModemStatusPolling() {
+	if d.modemStatusQuit == nil {
+		return
+	}
+	close(d.modemStatusQuit)
+	d.modemStatusWg.Wait()
+	d.modemStatusQuit = nil
+}
+
+// restartModemStatusPolling is called by Configure when status_poll_ms
+// changes on an already-open port, so the new interval takes effect
+// immediately rather than on the next Open.
+func (d *SerialMonitor) restartModemStatusPolling() error {
+	d.stopModemStatusPolling()
+	d.startModemStatusPolling()
+	return nil
+}
+
+func (d *SerialMonitor) pollModemStatus(quit chan struct{}) {
+	defer d.modemStatusWg.Done()
+
+	var cts, dsr, ri, dcd bool
+	first := true
+
+	for {
+		interval := d.statusPollInterval()
+		select {
+		case <-quit:
+			return
+		case <-time.After(interval):
+		}
+
+		bits, err := d.serialPort.GetModemStatusBits()
+		if err != nil {
+			continue
+		}
+		if first || bits.CTS != cts || bits.DSR != dsr || bits.RI != ri || bits.DCD != dcd {
+			cts, dsr, ri, dcd = bits.CTS, bits.DSR, bits.RI, bits.DCD
+			first = false
+			d.setModemStatusBit("cts", cts)
+			d.setModemStatusBit("dsr", dsr)
+			d.setModemStatusBit("ri", ri)
+			d.setModemStatusBit("dcd", dcd)
+		}
+	}
+}
+
+// setModemStatusBit updates one of the readonly "cts"/"dsr"/"ri"/"dcd"
+// parameters. It runs on the polling goroutine and races with Configure/
+// Describe on the handler's goroutine, so it takes settingsMu like they do.
+func (d *SerialMonitor) setModemStatusBit(parameterName string, on bool) {
+	value := "off"
+	if on {
+		value = "on"
+	}
+	d.settingsMu.Lock()
+	d.serialSettings.ConfigurationParameter[parameterName].Selected = value
+	d.settingsMu.Unlock()
+}
+
+// statusPollInterval reads "status_poll_ms", which Configure can update from
+// the handler's goroutine while this runs on the polling goroutine, hence
+// the settingsMu lock.
+func (d *SerialMonitor) statusPollInterval() time.Duration {
+	d.settingsMu.Lock()
+	selected := d.serialSettings.ConfigurationParameter["status_poll_ms"].Selected
+	d.settingsMu.Unlock()
+
+	ms, err := strconv.Atoi(selected)
+	if err != nil || ms < minStatusPollMs {
+		ms = minStatusPollMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}